@@ -4,9 +4,13 @@ import (
 	"app/base/utils"
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -22,18 +26,98 @@ const Rfc3339NoTz = "2006-01-02T15:04:05-07:00"
 var Context context.Context
 var CancelContext context.CancelFunc
 
+// nolint: gochecknoglobals
+var draining int32
+
+// nolint: gochecknoglobals
+var (
+	shutdownHooksMu sync.Mutex
+	shutdownHooks   []shutdownHook
+)
+
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
 func init() {
 	Context, CancelContext = context.WithCancel(context.Background())
 }
 
+// RegisterShutdownHook registers fn to run during the drain phase of the
+// graceful shutdown triggered by HandleSignals, before base.Context is
+// cancelled. Registered hooks run concurrently, each given up to
+// utils.Cfg.ShutdownGracePeriod (shared across all of them, not per-hook) to
+// return; a hook still running when the grace period elapses, or a second
+// signal arrives, is abandoned rather than waited for further.
+func RegisterShutdownHook(name string, fn func(ctx context.Context) error) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, shutdownHook{name: name, fn: fn})
+}
+
+// Draining reports whether the process has received a shutdown signal and is
+// waiting for registered shutdown hooks to finish. Handlers can use this to
+// fail health checks (e.g. return 503 from /healthz) so a load balancer stops
+// sending new traffic while in-flight work finishes.
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// HandleSignals performs a two-phase graceful shutdown. On the first
+// SIGINT/SIGTERM, it marks the process as draining (see Draining) and waits
+// up to utils.Cfg.ShutdownGracePeriod for every hook registered via
+// RegisterShutdownHook to finish, then cancels Context. A second
+// SIGINT/SIGTERM short-circuits that wait and cancels Context immediately.
 func HandleSignals() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	go handleShutdownSignals(c)
+}
+
+func handleShutdownSignals(c <-chan os.Signal) {
+	<-c
+	utils.Log().Info("SIGTERM/SIGINT received, draining before shutdown")
+	atomic.StoreInt32(&draining, 1)
+
+	done := make(chan struct{})
 	go func() {
-		<-c
-		CancelContext()
-		utils.Log().Info("SIGTERM/SIGINT handled")
+		runShutdownHooks()
+		close(done)
 	}()
+
+	select {
+	case <-done:
+		utils.Log().Info("shutdown hooks finished")
+	case <-time.After(utils.Cfg.ShutdownGracePeriod):
+		utils.Log().Warn("shutdown grace period elapsed before all hooks finished")
+	case <-c:
+		utils.Log().Warn("second SIGTERM/SIGINT received, shutting down immediately")
+	}
+
+	CancelContext()
+	utils.Log().Info("SIGTERM/SIGINT handled")
+}
+
+func runShutdownHooks() {
+	shutdownHooksMu.Lock()
+	hooks := append([]shutdownHook(nil), shutdownHooks...)
+	shutdownHooksMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), utils.Cfg.ShutdownGracePeriod)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, h := range hooks {
+		wg.Add(1)
+		go func(h shutdownHook) {
+			defer wg.Done()
+			if err := h.fn(ctx); err != nil {
+				utils.Log("hook", h.name, "err", err.Error()).Error("shutdown hook failed")
+			}
+		}(h)
+	}
+	wg.Wait()
 }
 
 func remove(r rune) rune {
@@ -97,15 +181,34 @@ func (d *Rfc3339TimestampWithZ) Time() *time.Time {
 	return (*time.Time)(d)
 }
 
-// TryExposeOnMetricsPort Expose app on required port if set
+// TryExposeOnMetricsPort exposes app on the configured metrics port, if set,
+// and registers the server's own graceful Shutdown as a shutdown hook so
+// in-flight scrapes finish draining before base.Context is cancelled.
 func TryExposeOnMetricsPort(app *gin.Engine) {
 	metricsPort := utils.Cfg.MetricsPort
 	if metricsPort == -1 {
 		return // Do not expose extra metrics port if not set
 	}
-	err := utils.RunServer(Context, app, metricsPort)
-	if err != nil {
+
+	if err := ListenAndServe("metrics-server", fmt.Sprintf(":%d", metricsPort), app); err != nil {
 		utils.Log("err", err.Error()).Error()
 		panic(err)
 	}
 }
+
+// ListenAndServe starts app's HTTP server on addr, registering the server's
+// own graceful Shutdown as a shutdown hook (see RegisterShutdownHook) so
+// in-flight requests finish draining before base.Context is cancelled. name
+// identifies the server in shutdown-hook logging (e.g. "manager-api"). Every
+// main gin engine — not just the metrics server TryExposeOnMetricsPort
+// starts — should be brought up through here instead of calling
+// server.ListenAndServe directly, so it drains the same way.
+func ListenAndServe(name, addr string, app *gin.Engine) error {
+	server := &http.Server{Addr: addr, Handler: app}
+	RegisterShutdownHook(name, server.Shutdown)
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
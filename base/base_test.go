@@ -0,0 +1,106 @@
+package base
+
+import (
+	"app/base/utils"
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// resetShutdownState rewinds the package-level shutdown state so tests don't
+// leak hooks or a cancelled Context into one another.
+func resetShutdownState(t *testing.T, gracePeriod time.Duration) {
+	shutdownHooksMu.Lock()
+	shutdownHooks = nil
+	shutdownHooksMu.Unlock()
+
+	atomic.StoreInt32(&draining, 0)
+	utils.Cfg.ShutdownGracePeriod = gracePeriod
+	Context, CancelContext = context.WithCancel(context.Background())
+	t.Cleanup(CancelContext)
+}
+
+func TestHandleShutdownSignalsWaitsForRegisteredHook(t *testing.T) {
+	resetShutdownState(t, time.Second)
+
+	hookDone := make(chan struct{})
+	RegisterShutdownHook("slow", func(ctx context.Context) error {
+		time.Sleep(200 * time.Millisecond)
+		close(hookDone)
+		return nil
+	})
+
+	c := make(chan os.Signal, 1)
+	go handleShutdownSignals(c)
+	c <- os.Interrupt
+
+	assert.Eventually(t, Draining, 50*time.Millisecond, 5*time.Millisecond)
+
+	select {
+	case <-Context.Done():
+		t.Fatal("Context was cancelled before the shutdown hook finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	select {
+	case <-hookDone:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown hook never finished")
+	}
+
+	select {
+	case <-Context.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Context was not cancelled after the shutdown hook finished")
+	}
+}
+
+func TestListenAndServeRegistersShutdownHook(t *testing.T) {
+	resetShutdownState(t, time.Second)
+
+	app := gin.New()
+	errCh := make(chan error, 1)
+	go func() { errCh <- ListenAndServe("test-server", "127.0.0.1:0", app) }()
+
+	assert.Eventually(t, func() bool {
+		shutdownHooksMu.Lock()
+		defer shutdownHooksMu.Unlock()
+		return len(shutdownHooks) == 1
+	}, time.Second, 5*time.Millisecond, "ListenAndServe should register its server's Shutdown as a hook")
+
+	runShutdownHooks()
+
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("ListenAndServe did not return after its shutdown hook ran")
+	}
+}
+
+func TestHandleShutdownSignalsSecondSignalShortCircuitsWait(t *testing.T) {
+	resetShutdownState(t, 10*time.Second)
+
+	RegisterShutdownHook("very-slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	c := make(chan os.Signal, 1)
+	go handleShutdownSignals(c)
+	c <- os.Interrupt
+	assert.Eventually(t, Draining, 50*time.Millisecond, 5*time.Millisecond)
+
+	c <- os.Interrupt
+
+	select {
+	case <-Context.Done():
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("a second signal should cancel Context immediately, without waiting for the grace period")
+	}
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type bulkTestModel struct {
+	ID        uint `gorm:"primarykey"`
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&bulkTestModel{}))
+	return db
+}
+
+func TestBulkInsertChunkSplitsIntoChunks(t *testing.T) {
+	db := openTestDB(t)
+
+	objects := make([]bulkTestModel, 0, 10)
+	for i := 0; i < 10; i++ {
+		objects = append(objects, bulkTestModel{Name: "obj"})
+	}
+
+	errs := BulkInsertChunk(db, objects, 3, DefaultRetryPolicy)
+	assert.Nil(t, errs)
+
+	var count int64
+	assert.NoError(t, db.Model(&bulkTestModel{}).Count(&count).Error)
+	assert.Equal(t, int64(10), count)
+}
+
+func TestBulkInsertChunkZeroLengthSlice(t *testing.T) {
+	db := openTestDB(t)
+
+	errs := BulkInsertChunk(db, []bulkTestModel{}, 100, DefaultRetryPolicy)
+	assert.Nil(t, errs)
+
+	var count int64
+	assert.NoError(t, db.Model(&bulkTestModel{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestBulkInsertChunkNotASlice(t *testing.T) {
+	db := openTestDB(t)
+
+	errs := BulkInsertChunk(db, bulkTestModel{Name: "obj"}, 100, DefaultRetryPolicy)
+	assert.Len(t, errs, 1)
+}
+
+func TestBulkInsertPopulatesTimestamps(t *testing.T) {
+	db := openTestDB(t)
+
+	objects := []bulkTestModel{{Name: "a"}, {Name: "b"}}
+	assert.NoError(t, BulkInsert(db, objects))
+
+	var stored []bulkTestModel
+	assert.NoError(t, db.Find(&stored).Error)
+	assert.Len(t, stored, 2)
+	for _, o := range stored {
+		assert.False(t, o.CreatedAt.IsZero())
+		assert.False(t, o.UpdatedAt.IsZero())
+	}
+}
+
+func TestBulkInsertSkipsBlankPrimaryKey(t *testing.T) {
+	db := openTestDB(t)
+
+	objects := []bulkTestModel{{Name: "a"}, {Name: "b"}}
+	assert.NoError(t, BulkInsert(db, objects))
+
+	var stored []bulkTestModel
+	assert.NoError(t, db.Order("name").Find(&stored).Error)
+	assert.Len(t, stored, 2)
+	// A blank ID must not have been sent as a literal 0 (which sqlite/postgres
+	// would happily insert); the DB's own sequence/rowid default assigns it.
+	assert.NotZero(t, stored[0].ID)
+	assert.NotZero(t, stored[1].ID)
+	assert.NotEqual(t, stored[0].ID, stored[1].ID)
+}
+
+func TestBulkInsertKeepsExplicitPrimaryKey(t *testing.T) {
+	db := openTestDB(t)
+
+	assert.NoError(t, BulkInsert(db, []bulkTestModel{{ID: 42, Name: "a"}}))
+
+	var stored bulkTestModel
+	assert.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, uint(42), stored.ID)
+}
+
+func TestBulkInsertChunksAtDefaultSize(t *testing.T) {
+	db := openTestDB(t)
+
+	objects := make([]bulkTestModel, 0, DefaultBulkChunkSize+1)
+	for i := 0; i < DefaultBulkChunkSize+1; i++ {
+		objects = append(objects, bulkTestModel{Name: "obj"})
+	}
+
+	assert.NoError(t, BulkInsert(db, objects))
+
+	var count int64
+	assert.NoError(t, db.Model(&bulkTestModel{}).Count(&count).Error)
+	assert.Equal(t, int64(DefaultBulkChunkSize+1), count)
+}
@@ -0,0 +1,73 @@
+//go:build integration
+
+// Advisory-lock contention can't be exercised against the sqlite driver used
+// by the rest of this package's tests (pg_advisory_lock is Postgres-only), so
+// this test runs only against a real database and is gated behind the
+// `integration` build tag, e.g.:
+//
+//	TEST_DATABASE_URL=postgres://... go test -tags=integration ./base/database/migrations/...
+package migrations
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func openIntegrationDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping advisory lock integration test")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	return db
+}
+
+func TestRunSerializesConcurrentCallersViaAdvisoryLock(t *testing.T) {
+	dbA := openIntegrationDB(t)
+	dbB := openIntegrationDB(t)
+
+	var order []string
+	var mu sync.Mutex
+	record := func(tag string) {
+		mu.Lock()
+		order = append(order, tag)
+		mu.Unlock()
+	}
+
+	slow := Migration{
+		ID:       "20240101000000",
+		Checksum: "1",
+		Migrate: func(db *gorm.DB) error {
+			record("A-start")
+			time.Sleep(200 * time.Millisecond)
+			record("A-end")
+			return nil
+		},
+	}
+	fast := Migration{
+		ID:       "20240102000000",
+		Checksum: "1",
+		Migrate: func(db *gorm.DB) error {
+			record("B-start")
+			return nil
+		},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); assert.NoError(t, run(dbA, []Migration{slow})) }()
+	time.Sleep(50 * time.Millisecond) // give dbA a head start acquiring the lock
+	go func() { defer wg.Done(); assert.NoError(t, run(dbB, []Migration{fast})) }()
+	wg.Wait()
+
+	// B must not start running until A has released the advisory lock, i.e.
+	// after A-end, even though B's own migration does no work at all.
+	assert.Equal(t, []string{"A-start", "A-end", "B-start"}, order)
+}
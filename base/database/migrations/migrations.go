@@ -0,0 +1,57 @@
+// Package migrations is a small xormigrate/gormigrate-style schema migration
+// runner. Individual migrations live in their own file, named by their
+// timestamped ID (YYYYMMDDHHMMSS_description.go), and register themselves
+// from an init() func:
+//
+//	func init() {
+//		Register(Migration{
+//			ID:          "20240115120000",
+//			Description: "create advisory_account_data table",
+//			// Bump Checksum any time Migrate/Rollback's behavior changes, so
+//			// Run can tell a deliberate new migration apart from this one
+//			// having been edited in place after it already ran in prod.
+//			Checksum: "1",
+//			Migrate: func(db *gorm.DB) error {
+//				return db.Exec("CREATE TABLE ...").Error
+//			},
+//			Rollback: func(db *gorm.DB) error {
+//				return db.Exec("DROP TABLE advisory_account_data").Error
+//			},
+//		})
+//	}
+//
+// Call Run(db) once at startup, before the application begins serving, to
+// apply every migration that hasn't been applied yet.
+//
+// Run, RollbackLast, and RollbackTo are exposed as library calls only; this
+// package does not itself add a CLI subcommand or call Run from a main()
+// startup path. This trimmed module subset has no manager/listener
+// entrypoint of its own to wire that into — a real entrypoint should call Run(db)
+// before serving and add a migrate subcommand that calls RollbackLast/
+// RollbackTo.
+package migrations
+
+import "gorm.io/gorm"
+
+// Migration is a single reversible schema change.
+type Migration struct {
+	ID          string
+	Description string
+	// Checksum fingerprints this migration's behavior. It's supplied by the
+	// author (e.g. a hash of the migration's SQL, or just a manually bumped
+	// counter) rather than computed from source, since a deployed binary
+	// doesn't have its own source tree on disk to hash. Run refuses to start
+	// if a previously-applied ID is registered with a different Checksum.
+	Checksum string
+	Migrate  func(*gorm.DB) error
+	Rollback func(*gorm.DB) error
+}
+
+// nolint: gochecknoglobals
+var registry []Migration
+
+// Register adds a migration to the package-level registry. It's meant to be
+// called from a migration file's init() func, not from application code.
+func Register(m Migration) {
+	registry = append(registry, m)
+}
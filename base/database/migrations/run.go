@@ -0,0 +1,197 @@
+package migrations
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"app/base/utils"
+
+	"github.com/pkg/errors"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// advisoryLockKey identifies our Postgres session-level advisory lock, so
+// that when several pods start up at once only one of them actually runs
+// migrations while the rest wait.
+const advisoryLockKey = 872164001
+
+type schemaMigrationRecord struct {
+	ID        string    `gorm:"column:id;primaryKey"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+	Checksum  string    `gorm:"column:checksum"`
+}
+
+func (schemaMigrationRecord) TableName() string { return "schema_migrations" }
+
+// Run applies every migration registered via Register that hasn't already
+// been recorded in schema_migrations, in ascending ID order. Each migration
+// runs in its own transaction; Run stops at the first failure.
+func Run(db *gorm.DB) error {
+	return run(db, registry)
+}
+
+func run(db *gorm.DB, migrations []Migration) error {
+	if err := db.AutoMigrate(&schemaMigrationRecord{}); err != nil {
+		return errors.Wrap(err, "could not create schema_migrations table")
+	}
+
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	return withAdvisoryLock(db, func(tx *gorm.DB) error {
+		for _, m := range sorted {
+			if err := applyOne(tx, m); err != nil {
+				return errors.Wrapf(err, "migration %s (%s)", m.ID, m.Description)
+			}
+		}
+		return nil
+	})
+}
+
+func applyOne(db *gorm.DB, m Migration) error {
+	if m.Checksum == "" {
+		return errors.Errorf("migration %s has no Checksum set", m.ID)
+	}
+
+	var existing schemaMigrationRecord
+	err := db.Where("id = ?", m.ID).First(&existing).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		// Not yet applied, fall through.
+	case err != nil:
+		return err
+	default:
+		if existing.Checksum != m.Checksum {
+			return errors.Errorf(
+				"already applied with a different checksum on %s; add a new migration instead of editing this one",
+				existing.AppliedAt.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	utils.Log("migration", m.ID).Info("applying migration")
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := m.Migrate(tx); err != nil {
+			return err
+		}
+		return tx.Create(&schemaMigrationRecord{ID: m.ID, AppliedAt: time.Now(), Checksum: m.Checksum}).Error
+	})
+}
+
+// RollbackLast rolls back the most recently applied migration.
+func RollbackLast(db *gorm.DB) error {
+	return withAdvisoryLock(db, func(tx *gorm.DB) error {
+		var last schemaMigrationRecord
+		err := tx.Order("id desc").First(&last).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return rollbackOne(tx, last.ID)
+	})
+}
+
+// RollbackTo rolls back every applied migration with an ID greater than id,
+// most recent first.
+func RollbackTo(db *gorm.DB, id string) error {
+	return withAdvisoryLock(db, func(tx *gorm.DB) error {
+		var applied []schemaMigrationRecord
+		if err := tx.Where("id > ?", id).Order("id desc").Find(&applied).Error; err != nil {
+			return err
+		}
+		for _, rec := range applied {
+			if err := rollbackOne(tx, rec.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func rollbackOne(db *gorm.DB, id string) error {
+	m, ok := findByID(registry, id)
+	if !ok {
+		return errors.Errorf("no migration with ID %s is registered, cannot roll it back", id)
+	}
+	if m.Rollback == nil {
+		return errors.Errorf("migration %s has no Rollback func", id)
+	}
+
+	utils.Log("migration", id).Info("rolling back migration")
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := m.Rollback(tx); err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&schemaMigrationRecord{}).Error
+	})
+}
+
+func findByID(migrations []Migration, id string) (Migration, bool) {
+	for _, m := range migrations {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+// withAdvisoryLock serializes Run/RollbackLast/RollbackTo across pods via a
+// Postgres session-level advisory lock. pg_advisory_lock/pg_advisory_unlock
+// are scoped to the session (i.e. the underlying connection) that took the
+// lock, so the lock, the migration work, and the unlock all have to run on
+// the very same *sql.Conn — we can't just issue three independent calls
+// against db and trust the connection pool to hand us back the same one.
+// Non-Postgres dialects (e.g. the sqlite driver used in unit tests) don't
+// support advisory locks and don't need them, since a test process never
+// races itself.
+func withAdvisoryLock(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if db.Dialector.Name() != "postgres" {
+		return fn(db)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return errors.Wrap(err, "could not get underlying *sql.DB")
+	}
+
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "could not acquire a pinned connection for the migrations advisory lock")
+	}
+	defer conn.Close()
+
+	// Shallow-copy db.Config rather than passing it directly: gorm.Open calls
+	// Option.Apply on whatever *gorm.Config it's given, which mutates it in
+	// place, and we don't want that mutation visible on the caller's db.
+	cfg := *db.Config
+	pinned, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &cfg)
+	if err != nil {
+		return errors.Wrap(err, "could not open a gorm session on the pinned connection")
+	}
+
+	if err := pinned.Exec("SELECT pg_advisory_lock(?)", advisoryLockKey).Error; err != nil {
+		return errors.Wrap(err, "could not acquire migrations advisory lock")
+	}
+	defer func() {
+		var unlocked bool
+		if err := pinned.Raw("SELECT pg_advisory_unlock(?)", advisoryLockKey).Scan(&unlocked).Error; err != nil {
+			utils.Log("err", err.Error()).Error("could not release migrations advisory lock")
+			return
+		}
+		if !unlocked {
+			// pg_advisory_unlock returning false means this session wasn't
+			// holding the lock, i.e. it landed on a different connection
+			// than the lock did. The lock is still held somewhere and will
+			// wedge every future migration run until that connection closes.
+			utils.Log("lock_key", advisoryLockKey).
+				Error("pg_advisory_unlock reported the lock was not held by this connection")
+		}
+	}()
+
+	return fn(pinned)
+}
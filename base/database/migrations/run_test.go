@@ -0,0 +1,165 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	return db
+}
+
+func TestRunAppliesMigrationsInIDOrder(t *testing.T) {
+	db := openTestDB(t)
+
+	var applied []string
+	migrations := []Migration{
+		{
+			ID:       "20240103000000",
+			Checksum: "1",
+			Migrate: func(db *gorm.DB) error {
+				applied = append(applied, "20240103000000")
+				return nil
+			},
+		},
+		{
+			ID:       "20240101000000",
+			Checksum: "1",
+			Migrate: func(db *gorm.DB) error {
+				applied = append(applied, "20240101000000")
+				return nil
+			},
+		},
+		{
+			ID:       "20240102000000",
+			Checksum: "1",
+			Migrate: func(db *gorm.DB) error {
+				applied = append(applied, "20240102000000")
+				return nil
+			},
+		},
+	}
+
+	assert.NoError(t, run(db, migrations))
+	assert.Equal(t, []string{"20240101000000", "20240102000000", "20240103000000"}, applied)
+
+	var count int64
+	assert.NoError(t, db.Model(&schemaMigrationRecord{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestRunSkipsAlreadyAppliedMigrations(t *testing.T) {
+	db := openTestDB(t)
+
+	runs := 0
+	migration := Migration{
+		ID:       "20240101000000",
+		Checksum: "1",
+		Migrate: func(db *gorm.DB) error {
+			runs++
+			return nil
+		},
+	}
+
+	assert.NoError(t, run(db, []Migration{migration}))
+	assert.NoError(t, run(db, []Migration{migration}))
+	assert.Equal(t, 1, runs)
+}
+
+func TestRunStopsAndRollsBackOnFailure(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.Exec("CREATE TABLE widgets (name text)").Error)
+
+	migrations := []Migration{
+		{
+			ID:       "20240101000000",
+			Checksum: "1",
+			Migrate: func(db *gorm.DB) error {
+				return db.Exec("INSERT INTO widgets (name) VALUES ('ok')").Error
+			},
+		},
+		{
+			ID:       "20240102000000",
+			Checksum: "1",
+			Migrate: func(db *gorm.DB) error {
+				if err := db.Exec("INSERT INTO widgets (name) VALUES ('partial')").Error; err != nil {
+					return err
+				}
+				return errors.New("boom")
+			},
+		},
+	}
+
+	err := run(db, migrations)
+	assert.Error(t, err)
+
+	var names []string
+	assert.NoError(t, db.Raw("SELECT name FROM widgets").Scan(&names).Error)
+	assert.Equal(t, []string{"ok"}, names, "the failed migration's own insert must have been rolled back")
+
+	var count int64
+	assert.NoError(t, db.Model(&schemaMigrationRecord{}).Where("id = ?", "20240102000000").Count(&count).Error)
+	assert.Equal(t, int64(0), count, "a failed migration must not be recorded as applied")
+}
+
+func TestRunRefusesAlreadyAppliedMigrationWithChangedChecksum(t *testing.T) {
+	db := openTestDB(t)
+
+	original := Migration{
+		ID:       "20240101000000",
+		Checksum: "1",
+		Migrate:  func(db *gorm.DB) error { return nil },
+	}
+	assert.NoError(t, run(db, []Migration{original}))
+
+	edited := original
+	edited.Checksum = "2"
+
+	err := run(db, []Migration{edited})
+	assert.Error(t, err, "a migration applied under one checksum must not silently re-run or be skipped under a different one")
+	assert.Contains(t, err.Error(), "already applied with a different checksum")
+}
+
+func TestRollbackLastReversesMostRecentMigration(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, db.Exec("CREATE TABLE widgets (name text)").Error)
+
+	registry = nil
+	Register(Migration{
+		ID:       "20240101000000",
+		Checksum: "1",
+		Migrate: func(db *gorm.DB) error {
+			return db.Exec("INSERT INTO widgets (name) VALUES ('a')").Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.Exec("DELETE FROM widgets WHERE name = 'a'").Error
+		},
+	})
+	Register(Migration{
+		ID:       "20240102000000",
+		Checksum: "1",
+		Migrate: func(db *gorm.DB) error {
+			return db.Exec("INSERT INTO widgets (name) VALUES ('b')").Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.Exec("DELETE FROM widgets WHERE name = 'b'").Error
+		},
+	})
+
+	assert.NoError(t, Run(db))
+	assert.NoError(t, RollbackLast(db))
+
+	var names []string
+	assert.NoError(t, db.Raw("SELECT name FROM widgets").Scan(&names).Error)
+	assert.Equal(t, []string{"a"}, names)
+
+	var count int64
+	assert.NoError(t, db.Model(&schemaMigrationRecord{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	"app/base"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// DefaultRetryPolicy retries the transient errors we actually see under
+// concurrent ingest: serialization failures, deadlocks, and unique-violation
+// races (the last of these also triggers the row-by-row fallback below).
+// nolint: gochecknoglobals
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     ExponentialJitter{Base: 50 * time.Millisecond, Max: 2 * time.Second},
+	RetryOn:     []string{pgerrcode.SerializationFailure, pgerrcode.DeadlockDetected, pgerrcode.UniqueViolation},
+}
+
+// RetryPolicy configures how BulkInsertChunk retries a chunk whose INSERT
+// failed with one of the given Postgres SQLSTATE codes (see pgerrcode).
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     Backoff
+	RetryOn     []string
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p RetryPolicy) retryable(code string) bool {
+	for _, c := range p.RetryOn {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) wait(attempt int) time.Duration {
+	if p.Backoff == nil {
+		return 0
+	}
+	return p.Backoff.Backoff(attempt)
+}
+
+// Backoff computes how long to sleep before retry attempt number attempt
+// (1-indexed: it's the delay after that attempt failed).
+type Backoff interface {
+	Backoff(attempt int) time.Duration
+}
+
+// ExponentialJitter doubles Base on every attempt, capped at Max, and returns
+// a uniformly random duration in [0, computed] so that concurrent callers
+// retrying the same conflict don't all wake up at once.
+type ExponentialJitter struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (e ExponentialJitter) Backoff(attempt int) time.Duration {
+	d := e.Base << uint(attempt-1)
+	if d <= 0 || d > e.Max {
+		d = e.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1)) // nolint:gosec
+}
+
+// ChunkError is returned by BulkInsertChunk for a chunk that failed: after
+// exhausting its retry policy, on the first non-retryable error, or when
+// base.Context was cancelled between attempts. It carries enough context to
+// correlate the failure with ingest logs.
+type ChunkError struct {
+	Index    int
+	Attempts int
+	Err      error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("bulk insert chunk %d failed after %d attempt(s): %s", e.Index, e.Attempts, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error { return e.Err }
+
+// chunkExecFunc performs a single (non-retried) chunk insert attempt. It's a
+// package-level var, rather than a direct call to bulkExec, purely so tests
+// can swap in a driver that scripts specific pg errors without standing up a
+// real database/sql driver.
+// nolint: gochecknoglobals
+var chunkExecFunc = bulkExec
+
+// execChunkWithRetry runs chunkExecFunc for one chunk, retrying on the
+// SQLSTATE codes listed in retry.RetryOn. If the final attempt against the
+// whole chunk ends in a unique violation, it falls back to inserting
+// row-by-row so a single poison record can't fail the entire chunk.
+func execChunkWithRetry(db *gorm.DB, objects interface{}, retry RetryPolicy, chunkIndex int) error {
+	maxAttempts := retry.maxAttempts()
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		lastErr = chunkExecFunc(db, objects)
+		if lastErr == nil {
+			return nil
+		}
+
+		code, ok := pgErrorCode(lastErr)
+		if !ok || !retry.retryable(code) {
+			return &ChunkError{Index: chunkIndex, Attempts: attempt, Err: lastErr}
+		}
+
+		if attempt == maxAttempts {
+			if code == pgerrcode.UniqueViolation {
+				return execRowByRow(db, objects, chunkIndex)
+			}
+			break
+		}
+
+		select {
+		case <-time.After(retry.wait(attempt)):
+		case <-base.Context.Done():
+			return &ChunkError{Index: chunkIndex, Attempts: attempt, Err: base.Context.Err()}
+		}
+	}
+
+	return &ChunkError{Index: chunkIndex, Attempts: maxAttempts, Err: lastErr}
+}
+
+// execRowByRow re-issues a chunk one row at a time, so a single row that
+// keeps hitting a unique violation doesn't take the rest of the chunk down
+// with it.
+func execRowByRow(db *gorm.DB, objects interface{}, chunkIndex int) error {
+	v := reflect.ValueOf(objects)
+
+	var rowErrors []string
+	for i := 0; i < v.Len(); i++ {
+		row := v.Slice(i, i+1).Interface()
+		if err := chunkExecFunc(db, row); err != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("row %d: %s", i, err))
+		}
+	}
+
+	if len(rowErrors) == 0 {
+		return nil
+	}
+
+	return &ChunkError{
+		Index:    chunkIndex,
+		Attempts: 1,
+		Err: errors.Errorf("row-by-row fallback: %d/%d rows still failed: %s",
+			len(rowErrors), v.Len(), strings.Join(rowErrors, "; ")),
+	}
+}
+
+func pgErrorCode(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code, true
+	}
+	return "", false
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"app/base"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// scriptedExec stands in for a real Postgres driver: it returns the next
+// queued error on each call (nil meaning "succeeds"), recording how many
+// times and with what objects it was invoked.
+type scriptedExec struct {
+	errs  []error
+	calls []interface{}
+}
+
+func (s *scriptedExec) exec(_ *gorm.DB, objects interface{}) error {
+	s.calls = append(s.calls, objects)
+	if len(s.calls) > len(s.errs) {
+		return nil
+	}
+	return s.errs[len(s.calls)-1]
+}
+
+func pgErr(code string) error {
+	return &pgconn.PgError{Code: code}
+}
+
+func withScriptedExec(t *testing.T, errs []error) *scriptedExec {
+	s := &scriptedExec{errs: errs}
+	original := chunkExecFunc
+	chunkExecFunc = s.exec
+	t.Cleanup(func() { chunkExecFunc = original })
+	return s
+}
+
+func TestExecChunkWithRetrySucceedsAfterTransientErrors(t *testing.T) {
+	s := withScriptedExec(t, []error{pgErr(pgerrcode.SerializationFailure), pgErr(pgerrcode.DeadlockDetected)})
+
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: ExponentialJitter{Base: time.Millisecond, Max: time.Millisecond},
+		RetryOn: []string{pgerrcode.SerializationFailure, pgerrcode.DeadlockDetected}}
+
+	err := execChunkWithRetry(nil, []int{1, 2, 3}, policy, 0)
+	assert.NoError(t, err)
+	assert.Len(t, s.calls, 3)
+}
+
+func TestExecChunkWithRetryGivesUpOnNonRetryableError(t *testing.T) {
+	s := withScriptedExec(t, []error{pgErr(pgerrcode.SyntaxError)})
+
+	policy := RetryPolicy{MaxAttempts: 5, RetryOn: []string{pgerrcode.SerializationFailure}}
+
+	err := execChunkWithRetry(nil, []int{1}, policy, 2)
+	assert.Error(t, err)
+	assert.Len(t, s.calls, 1, "a non-retryable error must not be retried")
+
+	var chunkErr *ChunkError
+	assert.ErrorAs(t, err, &chunkErr)
+	assert.Equal(t, 2, chunkErr.Index)
+	assert.Equal(t, 1, chunkErr.Attempts)
+}
+
+func TestExecChunkWithRetryFallsBackRowByRowOnRepeatedUniqueViolation(t *testing.T) {
+	s := withScriptedExec(t, []error{pgErr(pgerrcode.UniqueViolation), pgErr(pgerrcode.UniqueViolation)})
+
+	policy := RetryPolicy{MaxAttempts: 2, Backoff: ExponentialJitter{Base: time.Millisecond, Max: time.Millisecond},
+		RetryOn: []string{pgerrcode.UniqueViolation}}
+
+	err := execChunkWithRetry(nil, []int{1, 2, 3}, policy, 0)
+	assert.NoError(t, err)
+
+	// 2 whole-chunk attempts, then one call per row in the row-by-row fallback.
+	assert.Len(t, s.calls, 2+3)
+}
+
+func TestExecChunkWithRetryHonorsContextCancellation(t *testing.T) {
+	withScriptedExec(t, []error{pgErr(pgerrcode.SerializationFailure), pgErr(pgerrcode.SerializationFailure)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	originalCtx, originalCancel := base.Context, base.CancelContext
+	base.Context, base.CancelContext = ctx, cancel
+	t.Cleanup(func() { base.Context, base.CancelContext = originalCtx, originalCancel })
+
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: ExponentialJitter{Base: time.Hour, Max: time.Hour},
+		RetryOn: []string{pgerrcode.SerializationFailure}}
+
+	cancel()
+	err := execChunkWithRetry(nil, []int{1}, policy, 0)
+	assert.Error(t, err)
+	assert.ErrorIs(t, err.(*ChunkError).Err, context.Canceled)
+}
+
+func TestExponentialJitterStaysWithinBounds(t *testing.T) {
+	b := ExponentialJitter{Base: 10 * time.Millisecond, Max: 100 * time.Millisecond}
+	for attempt := 1; attempt <= 10; attempt++ {
+		d := b.Backoff(attempt)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, b.Max)
+	}
+}
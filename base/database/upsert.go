@@ -0,0 +1,176 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// UpsertOptions configures the ON CONFLICT behaviour of BulkInsertOpts.
+// ConflictColumns and UpdateColumns, when left empty, are derived from the
+// inserted objects' `upsert:"key"` / `upsert:"update"` struct tags instead.
+type UpsertOptions struct {
+	// ConflictColumns is the ON CONFLICT (...) target.
+	ConflictColumns []string
+	// UpdateColumns lists the columns set in DO UPDATE SET.
+	UpdateColumns []string
+	// DoNothing emits ON CONFLICT (...) DO NOTHING instead of DO UPDATE.
+	DoNothing bool
+	// WhereExcludedNewer, if set, appends a
+	// `WHERE <col> < EXCLUDED.<col>` guard to the DO UPDATE clause so
+	// out-of-order events can't overwrite a row with a newer value.
+	WhereExcludedNewer string
+}
+
+// BulkInsertOpts splits objects into chunkSize chunks like BulkInsertChunk,
+// except each chunk is inserted with an `ON CONFLICT ... DO UPDATE`/
+// `DO NOTHING` clause built from opts. Unlike BulkInsertChunk, it applies no
+// retry policy: a failed chunk's error is returned as is.
+func BulkInsertOpts(db *gorm.DB, objects interface{}, chunkSize int, opts UpsertOptions) []error {
+	var allErrors []error
+
+	if reflect.TypeOf(objects).Kind() != reflect.Slice {
+		return []error{errors.New("objects arg is not a slice")}
+	}
+
+	v := reflect.ValueOf(objects)
+	if v.Len() < 1 {
+		return nil
+	}
+
+	onConflict, err := buildOnConflict(db, v.Index(0).Interface(), opts)
+	if err != nil {
+		return []error{err}
+	}
+
+	for v.Len() > 0 {
+		n := chunkSize
+		if v.Len() < n {
+			n = v.Len()
+		}
+
+		chunkObjects := v.Slice(0, n).Interface()
+		v = v.Slice(n, v.Len())
+
+		if err := upsertExec(db, chunkObjects, onConflict); err != nil {
+			allErrors = append(allErrors, err)
+		}
+	}
+
+	if len(allErrors) > 0 {
+		return allErrors
+	}
+
+	return nil
+}
+
+// upsertExec mirrors bulkExec, but with the ON CONFLICT clause attached. It
+// still funnels every chunk through a single multi-row Create, so the same
+// CreateBatchSize-based parameter-count limit (65535) applies.
+func upsertExec(db *gorm.DB, objects interface{}, onConflict clause.OnConflict) error {
+	v := reflect.ValueOf(objects)
+	if v.Len() < 1 {
+		return nil
+	}
+
+	return db.Session(&gorm.Session{CreateBatchSize: v.Len()}).Clauses(onConflict).Create(objects).Error
+}
+
+func buildOnConflict(db *gorm.DB, sample interface{}, opts UpsertOptions) (clause.OnConflict, error) {
+	conflictColumns := opts.ConflictColumns
+	updateColumns := opts.UpdateColumns
+
+	if len(conflictColumns) == 0 || (len(updateColumns) == 0 && !opts.DoNothing) {
+		tagKey, tagUpdate, err := upsertColumnsFromTags(db, sample)
+		if err != nil {
+			return clause.OnConflict{}, err
+		}
+		if len(conflictColumns) == 0 {
+			conflictColumns = tagKey
+		}
+		if len(updateColumns) == 0 && !opts.DoNothing {
+			updateColumns = tagUpdate
+		}
+	}
+
+	if len(conflictColumns) == 0 {
+		return clause.OnConflict{}, errors.New("BulkInsertOpts: no ConflictColumns given and no field tagged `upsert:\"key\"`")
+	}
+
+	columns := make([]clause.Column, len(conflictColumns))
+	for i, c := range conflictColumns {
+		columns[i] = clause.Column{Name: c}
+	}
+
+	onConflict := clause.OnConflict{Columns: columns, DoNothing: opts.DoNothing}
+	if opts.DoNothing {
+		return onConflict, nil
+	}
+
+	if len(updateColumns) == 0 {
+		return clause.OnConflict{}, errors.New("BulkInsertOpts: no UpdateColumns given and no field tagged `upsert:\"update\"`")
+	}
+	onConflict.DoUpdates = clause.AssignmentColumns(updateColumns)
+
+	if opts.WhereExcludedNewer != "" {
+		onConflict.Where = clause.Where{Exprs: []clause.Expression{
+			clause.Expr{
+				SQL:  "? < EXCLUDED.?",
+				Vars: []interface{}{clause.Column{Name: opts.WhereExcludedNewer}, clause.Column{Name: opts.WhereExcludedNewer}},
+			},
+		}}
+	}
+
+	return onConflict, nil
+}
+
+// upsertColumnsFromTags reads the `upsert:"key"`/`upsert:"update"` struct
+// tags off sample's type and maps them to DB column names via schema.Parse.
+func upsertColumnsFromTags(db *gorm.DB, sample interface{}) (key, update []string, err error) {
+	rv := reflect.ValueOf(sample)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+		sample = rv.Interface()
+	}
+	rt := rv.Type()
+
+	sch, err := schema.Parse(sample, &sync.Map{}, db.NamingStrategy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dbNameByFieldName := make(map[string]string, len(sch.Fields))
+	for _, f := range sch.Fields {
+		dbNameByFieldName[f.Name] = f.DBName
+	}
+
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, ok := sf.Tag.Lookup("upsert")
+		if !ok {
+			continue
+		}
+		dbName, ok := dbNameByFieldName[sf.Name]
+		if !ok {
+			continue
+		}
+		for _, part := range strings.Split(tag, ",") {
+			switch strings.TrimSpace(part) {
+			case "key":
+				key = append(key, dbName)
+			case "update":
+				update = append(update, dbName)
+			default:
+				return nil, nil, fmt.Errorf("unknown upsert tag value %q on field %s", part, sf.Name)
+			}
+		}
+	}
+
+	return key, update, nil
+}
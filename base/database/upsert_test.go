@@ -0,0 +1,98 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type upsertTestModel struct {
+	InventoryID   string `gorm:"primarykey" upsert:"key"`
+	AdvisoryID    string `gorm:"primarykey" upsert:"key"`
+	Status        string `upsert:"update"`
+	WhenPatchable string `upsert:"update"`
+	Updated       time.Time
+}
+
+func openUpsertTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&upsertTestModel{}))
+	return db
+}
+
+func TestBulkInsertOptsDoNothing(t *testing.T) {
+	db := openUpsertTestDB(t)
+
+	row := upsertTestModel{InventoryID: "inv1", AdvisoryID: "adv1", Status: "first"}
+	assert.Nil(t, BulkInsertOpts(db, []upsertTestModel{row}, 10, UpsertOptions{DoNothing: true}))
+
+	dup := upsertTestModel{InventoryID: "inv1", AdvisoryID: "adv1", Status: "second"}
+	assert.Nil(t, BulkInsertOpts(db, []upsertTestModel{dup}, 10, UpsertOptions{DoNothing: true}))
+
+	var stored upsertTestModel
+	assert.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, "first", stored.Status)
+}
+
+func TestBulkInsertOptsDoUpdateSubsetOfColumns(t *testing.T) {
+	db := openUpsertTestDB(t)
+
+	row := upsertTestModel{InventoryID: "inv1", AdvisoryID: "adv1", Status: "first", WhenPatchable: "2024-01-01"}
+	assert.Nil(t, BulkInsertOpts(db, []upsertTestModel{row}, 10, UpsertOptions{}))
+
+	updated := upsertTestModel{InventoryID: "inv1", AdvisoryID: "adv1", Status: "second", WhenPatchable: "should-not-apply"}
+	errs := BulkInsertOpts(db, []upsertTestModel{updated}, 10, UpsertOptions{
+		ConflictColumns: []string{"inventory_id", "advisory_id"},
+		UpdateColumns:   []string{"status"},
+	})
+	assert.Nil(t, errs)
+
+	var stored upsertTestModel
+	assert.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, "second", stored.Status)
+	assert.Equal(t, "2024-01-01", stored.WhenPatchable, "column not in UpdateColumns must stay untouched")
+}
+
+func TestBulkInsertOptsCompositeKeyFromTags(t *testing.T) {
+	db := openUpsertTestDB(t)
+
+	row := upsertTestModel{InventoryID: "inv1", AdvisoryID: "adv1", Status: "first"}
+	assert.Nil(t, BulkInsertOpts(db, []upsertTestModel{row}, 10, UpsertOptions{}))
+
+	// Same InventoryID but a different AdvisoryID must be a distinct row,
+	// proving the conflict target is the (inventory_id, advisory_id) pair
+	// derived from the `upsert:"key"` tags, not just inventory_id.
+	other := upsertTestModel{InventoryID: "inv1", AdvisoryID: "adv2", Status: "second"}
+	assert.Nil(t, BulkInsertOpts(db, []upsertTestModel{other}, 10, UpsertOptions{}))
+
+	var count int64
+	assert.NoError(t, db.Model(&upsertTestModel{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestBulkInsertOptsWhereExcludedNewerSkipsStaleUpdate(t *testing.T) {
+	db := openUpsertTestDB(t)
+
+	newer := time.Now()
+	older := newer.Add(-time.Hour)
+
+	row := upsertTestModel{InventoryID: "inv1", AdvisoryID: "adv1", Status: "first", Updated: newer}
+	assert.Nil(t, BulkInsertOpts(db, []upsertTestModel{row}, 10, UpsertOptions{
+		UpdateColumns: []string{"status", "updated"},
+	}))
+
+	stale := upsertTestModel{InventoryID: "inv1", AdvisoryID: "adv1", Status: "stale", Updated: older}
+	errs := BulkInsertOpts(db, []upsertTestModel{stale}, 10, UpsertOptions{
+		UpdateColumns:      []string{"status", "updated"},
+		WhereExcludedNewer: "updated",
+	})
+	assert.Nil(t, errs)
+
+	var stored upsertTestModel
+	assert.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, "first", stored.Status, "an out-of-order older update must not overwrite a newer row")
+}